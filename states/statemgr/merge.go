@@ -0,0 +1,297 @@
+package statemgr
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+)
+
+// MergeStrategy selects how Merge (and Import, when it delegates to Merge)
+// should resolve a conflicting resource instance, where "conflicting" means
+// the instance was changed on both sides relative to the common ancestor.
+type MergeStrategy int
+
+const (
+	// MergeStrategyManual returns any conflicting resource instances as
+	// Conflict values for the caller to resolve, rather than resolving them
+	// automatically. This is the default strategy for interactive use.
+	MergeStrategyManual MergeStrategy = iota
+
+	// MergeStrategyOurs resolves every conflict in favor of the "ours"
+	// snapshot, corresponding to a --ours flag on the importing command.
+	MergeStrategyOurs
+
+	// MergeStrategyTheirs resolves every conflict in favor of the "theirs"
+	// snapshot, corresponding to a --theirs flag on the importing command.
+	MergeStrategyTheirs
+)
+
+// HistoryReader is an optional interface implemented by state managers whose
+// backing store retains a history of prior snapshots, such as the S3 manager
+// when paired with a DynamoDB lock table that records serials, or Terraform
+// Cloud's state version history.
+//
+// Merge uses HistoryReader to locate the common ancestor snapshot for a
+// three-way merge. A manager that doesn't implement this interface can still
+// be used with Import, but a serial collision will be reported as a plain
+// error rather than resolved with a merge. A lineage mismatch is reported as
+// a plain error whether or not the manager implements this interface.
+type HistoryReader interface {
+	// SnapshotAt returns the state snapshot that was persisted with the
+	// given lineage and serial, or an error if no such snapshot is retained.
+	SnapshotAt(lineage string, serial uint64) (*statefile.File, error)
+}
+
+// Conflict describes a single resource instance object that was changed on
+// both sides of a three-way merge relative to their common ancestor, and so
+// cannot be merged automatically.
+type Conflict struct {
+	Addr addrs.AbsResourceInstance
+
+	// DeposedKey is the zero value if this conflict is over the instance's
+	// current object. Otherwise, it identifies which of the instance's
+	// deposed objects -- left behind mid create_before_destroy replacement
+	// -- the conflict is over.
+	DeposedKey states.DeposedKey
+
+	// Base, Ours, and Theirs are the resource instance objects from the
+	// common ancestor, "ours", and "theirs" snapshots, respectively. Any of
+	// these may be nil, indicating that the instance did not exist in that
+	// snapshot.
+	Base, Ours, Theirs *states.ResourceInstanceObjectSrc
+}
+
+// Merge performs a resource-instance-level three-way merge of the "ours" and
+// "theirs" state snapshots against their common ancestor, "base".
+//
+// Resource instances that were added, removed, or updated in only one of
+// "ours" or "theirs" are merged automatically. Resource instances that were
+// changed on both sides are reported as Conflict values and left unresolved
+// in the returned state, unless resolved automatically per the given
+// MergeStrategy.
+//
+// Merge does not modify any of its arguments.
+func Merge(base, ours, theirs *statefile.File, strategy MergeStrategy) (*statefile.File, []Conflict, error) {
+	if ours.Lineage != theirs.Lineage {
+		return nil, nil, fmt.Errorf("cannot merge unrelated states with lineages %q and %q", ours.Lineage, theirs.Lineage)
+	}
+
+	merged := ours.State.DeepCopy()
+	var conflicts []Conflict
+
+	for _, addr := range allResourceInstanceAddrs(base.State, ours.State, theirs.State) {
+		baseSnap := resourceInstanceSnapshot(base.State, addr)
+		oursSnap := resourceInstanceSnapshot(ours.State, addr)
+		theirsSnap := resourceInstanceSnapshot(theirs.State, addr)
+
+		switch decideMerge(baseSnap, oursSnap, theirsSnap, strategy) {
+		case decisionTakeTheirs:
+			setResourceInstanceObject(merged, addr, theirsSnap)
+		case decisionConflict:
+			conflicts = append(conflicts, Conflict{
+				Addr:   addr,
+				Base:   baseSnap.Obj,
+				Ours:   oursSnap.Obj,
+				Theirs: theirsSnap.Obj,
+			})
+		}
+
+		// A create_before_destroy replacement can leave an instance with
+		// deposed objects alongside (or instead of) a current one; merge
+		// those the same way, independently per deposed key.
+		for _, key := range allDeposedKeys(addr, base.State, ours.State, theirs.State) {
+			baseDep := deposedInstanceSnapshot(base.State, addr, key)
+			oursDep := deposedInstanceSnapshot(ours.State, addr, key)
+			theirsDep := deposedInstanceSnapshot(theirs.State, addr, key)
+
+			switch decideMerge(baseDep, oursDep, theirsDep, strategy) {
+			case decisionTakeTheirs:
+				setDeposedObject(merged, addr, key, theirsDep)
+			case decisionConflict:
+				conflicts = append(conflicts, Conflict{
+					Addr:       addr,
+					DeposedKey: key,
+					Base:       baseDep.Obj,
+					Ours:       oursDep.Obj,
+					Theirs:     theirsDep.Obj,
+				})
+			}
+		}
+	}
+
+	result := statefile.New(merged, ours.Lineage, ours.Serial+1)
+	return result, conflicts, nil
+}
+
+// mergeDecision is the outcome of comparing one resource instance object (or
+// deposed object) across the base, ours, and theirs snapshots.
+type mergeDecision int
+
+const (
+	// decisionKeepOurs means the merged result should retain whatever ours
+	// already has, whether that's an unchanged, ours-only-changed, or
+	// theirs-only-changed-to-match-base object.
+	decisionKeepOurs mergeDecision = iota
+
+	// decisionTakeTheirs means only theirs changed this object relative to
+	// base, so the merged result should take theirs's version.
+	decisionTakeTheirs
+
+	// decisionConflict means both sides changed this object differently
+	// and the given MergeStrategy didn't resolve it automatically.
+	decisionConflict
+)
+
+// decideMerge applies the three-way merge rule to a single object: if only
+// one side changed it relative to base, take that side; if both sides agree
+// there's nothing to do; if both sides changed it differently, resolve per
+// strategy or report a conflict.
+func decideMerge(base, ours, theirs snapshotObj, strategy MergeStrategy) mergeDecision {
+	switch {
+	case reflect.DeepEqual(ours.Obj, theirs.Obj):
+		return decisionKeepOurs
+	case reflect.DeepEqual(base.Obj, ours.Obj):
+		return decisionTakeTheirs
+	case reflect.DeepEqual(base.Obj, theirs.Obj):
+		return decisionKeepOurs
+	default:
+		switch strategy {
+		case MergeStrategyTheirs:
+			return decisionTakeTheirs
+		case MergeStrategyOurs:
+			return decisionKeepOurs
+		default:
+			return decisionConflict
+		}
+	}
+}
+
+// allResourceInstanceAddrs returns the union of all resource instance
+// addresses present in any of the given states, in a deterministic order.
+func allResourceInstanceAddrs(states_ ...*states.State) []addrs.AbsResourceInstance {
+	seen := make(map[string]addrs.AbsResourceInstance)
+	var order []string
+	for _, s := range states_ {
+		if s == nil {
+			continue
+		}
+		for _, m := range s.Modules {
+			for _, r := range m.Resources {
+				for key := range r.Instances {
+					addr := r.Addr.Instance(key).Absolute(m.Addr)
+					k := addr.String()
+					if _, ok := seen[k]; !ok {
+						seen[k] = addr
+						order = append(order, k)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(order)
+	result := make([]addrs.AbsResourceInstance, len(order))
+	for i, k := range order {
+		result[i] = seen[k]
+	}
+	return result
+}
+
+// snapshotObj bundles a resource instance's current object together with the
+// provider configuration that owns it, so that merging an instance from one
+// state into another doesn't lose track of which provider it belongs to.
+type snapshotObj struct {
+	Obj      *states.ResourceInstanceObjectSrc
+	Provider addrs.AbsProviderConfig
+}
+
+// resourceInstanceSnapshot returns the current object and owning provider
+// configuration for the given resource instance address, or a zero value if
+// the state has no such instance.
+func resourceInstanceSnapshot(s *states.State, addr addrs.AbsResourceInstance) snapshotObj {
+	if s == nil {
+		return snapshotObj{}
+	}
+	is := s.ResourceInstance(addr)
+	if is == nil || is.Current == nil {
+		return snapshotObj{}
+	}
+	var provider addrs.AbsProviderConfig
+	if rs := s.Resource(addr.ContainingResource()); rs != nil {
+		provider = rs.ProviderConfig
+	}
+	return snapshotObj{Obj: is.Current, Provider: provider}
+}
+
+// setResourceInstanceObject overwrites the current object for the given
+// resource instance address in s with snap, preserving snap's owning
+// provider configuration, or removes the instance entirely if snap has no
+// object.
+func setResourceInstanceObject(s *states.State, addr addrs.AbsResourceInstance, snap snapshotObj) {
+	if snap.Obj == nil {
+		s.RemoveResourceInstanceCurrent(addr)
+		return
+	}
+	s.SetResourceInstanceCurrent(addr, snap.Obj, snap.Provider)
+}
+
+// allDeposedKeys returns the union of all deposed object keys recorded for
+// addr across any of the given states, in a deterministic order.
+func allDeposedKeys(addr addrs.AbsResourceInstance, states_ ...*states.State) []states.DeposedKey {
+	seen := make(map[states.DeposedKey]bool)
+	var order []states.DeposedKey
+	for _, s := range states_ {
+		if s == nil {
+			continue
+		}
+		is := s.ResourceInstance(addr)
+		if is == nil {
+			continue
+		}
+		for key := range is.Deposed {
+			if !seen[key] {
+				seen[key] = true
+				order = append(order, key)
+			}
+		}
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	return order
+}
+
+// deposedInstanceSnapshot returns the deposed object and owning provider
+// configuration recorded under key for the given resource instance address,
+// or a zero value if the state has no such deposed object.
+func deposedInstanceSnapshot(s *states.State, addr addrs.AbsResourceInstance, key states.DeposedKey) snapshotObj {
+	if s == nil {
+		return snapshotObj{}
+	}
+	is := s.ResourceInstance(addr)
+	if is == nil {
+		return snapshotObj{}
+	}
+	obj, ok := is.Deposed[key]
+	if !ok {
+		return snapshotObj{}
+	}
+	var provider addrs.AbsProviderConfig
+	if rs := s.Resource(addr.ContainingResource()); rs != nil {
+		provider = rs.ProviderConfig
+	}
+	return snapshotObj{Obj: obj, Provider: provider}
+}
+
+// setDeposedObject overwrites the deposed object recorded under key for the
+// given resource instance address in s with snap, preserving snap's owning
+// provider configuration, or forgets that deposed object entirely if snap
+// has no object.
+func setDeposedObject(s *states.State, addr addrs.AbsResourceInstance, key states.DeposedKey, snap snapshotObj) {
+	if snap.Obj == nil {
+		s.ForgetResourceInstanceDeposed(addr, key)
+		return
+	}
+	s.SetResourceInstanceDeposed(addr, key, snap.Obj, snap.Provider)
+}
@@ -0,0 +1,215 @@
+package statemgr
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+)
+
+func testInstanceAddr(name string) addrs.AbsResourceInstance {
+	return addrs.Resource{
+		Mode: addrs.ManagedResourceMode,
+		Type: "test_instance",
+		Name: name,
+	}.Instance(addrs.NoKey).Absolute(addrs.RootModuleInstance)
+}
+
+func testProviderAddr() addrs.AbsProviderConfig {
+	return addrs.AbsProviderConfig{
+		Provider: addrs.NewDefaultProvider("test"),
+		Module:   addrs.RootModule,
+	}
+}
+
+func testObjSrc(json string) *states.ResourceInstanceObjectSrc {
+	return &states.ResourceInstanceObjectSrc{
+		AttrsJSON: []byte(json),
+		Status:    states.ObjectReady,
+	}
+}
+
+func testFile(lineage string, serial uint64, setup func(ss *states.SyncState)) *statefile.File {
+	s := states.BuildState(setup)
+	return statefile.New(s, lineage, serial)
+}
+
+func TestMerge(t *testing.T) {
+	unchanged := testInstanceAddr("unchanged")
+	oursOnly := testInstanceAddr("ours_only")
+	theirsOnly := testInstanceAddr("theirs_only")
+	conflicting := testInstanceAddr("conflicting")
+	provider := testProviderAddr()
+
+	base := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(unchanged, testObjSrc(`{"id":"base"}`), provider)
+		ss.SetResourceInstanceCurrent(oursOnly, testObjSrc(`{"id":"base"}`), provider)
+		ss.SetResourceInstanceCurrent(theirsOnly, testObjSrc(`{"id":"base"}`), provider)
+		ss.SetResourceInstanceCurrent(conflicting, testObjSrc(`{"id":"base"}`), provider)
+	})
+	ours := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(unchanged, testObjSrc(`{"id":"base"}`), provider)
+		ss.SetResourceInstanceCurrent(oursOnly, testObjSrc(`{"id":"ours"}`), provider)
+		ss.SetResourceInstanceCurrent(theirsOnly, testObjSrc(`{"id":"base"}`), provider)
+		ss.SetResourceInstanceCurrent(conflicting, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(unchanged, testObjSrc(`{"id":"base"}`), provider)
+		ss.SetResourceInstanceCurrent(oursOnly, testObjSrc(`{"id":"base"}`), provider)
+		ss.SetResourceInstanceCurrent(theirsOnly, testObjSrc(`{"id":"theirs"}`), provider)
+		ss.SetResourceInstanceCurrent(conflicting, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	merged, conflicts, err := Merge(base, ours, theirs, MergeStrategyManual)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d: %#v", len(conflicts), conflicts)
+	}
+	if got, want := conflicts[0].Addr.String(), conflicting.String(); got != want {
+		t.Errorf("wrong conflict address: got %s, want %s", got, want)
+	}
+
+	wantOursOnly := `{"id":"ours"}`
+	if got := string(merged.State.ResourceInstance(oursOnly).Current.AttrsJSON); got != wantOursOnly {
+		t.Errorf("ours-only instance = %s, want %s", got, wantOursOnly)
+	}
+	wantTheirsOnly := `{"id":"theirs"}`
+	if got := string(merged.State.ResourceInstance(theirsOnly).Current.AttrsJSON); got != wantTheirsOnly {
+		t.Errorf("theirs-only instance = %s, want %s", got, wantTheirsOnly)
+	}
+	if got := merged.State.Resource(conflicting.ContainingResource()).ProviderConfig; got.String() != provider.String() {
+		t.Errorf("conflicting instance lost its provider config: got %s, want %s", got, provider)
+	}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	addr := testInstanceAddr("conflicting")
+	provider := testProviderAddr()
+
+	base := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"base"}`), provider)
+	})
+	ours := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	t.Run("ours", func(t *testing.T) {
+		merged, conflicts, err := Merge(base, ours, theirs, MergeStrategyOurs)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(conflicts) != 0 {
+			t.Fatalf("expected no conflicts, got %#v", conflicts)
+		}
+		if got, want := string(merged.State.ResourceInstance(addr).Current.AttrsJSON), `{"id":"ours"}`; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+
+	t.Run("theirs", func(t *testing.T) {
+		merged, conflicts, err := Merge(base, ours, theirs, MergeStrategyTheirs)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(conflicts) != 0 {
+			t.Fatalf("expected no conflicts, got %#v", conflicts)
+		}
+		if got, want := string(merged.State.ResourceInstance(addr).Current.AttrsJSON), `{"id":"theirs"}`; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+		if got := merged.State.Resource(addr.ContainingResource()).ProviderConfig; got.String() != provider.String() {
+			t.Errorf("theirs instance lost its provider config: got %s, want %s", got, provider)
+		}
+	})
+}
+
+func TestMergeUnrelatedLineages(t *testing.T) {
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+
+	base := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"base"}`), provider)
+	})
+	ours := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("xyz", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	if _, _, err := Merge(base, ours, theirs, MergeStrategyManual); err == nil {
+		t.Fatal("expected an error for unrelated lineages, got none")
+	}
+}
+
+// TestMergeDeposedOnlyInstance exercises an instance that exists only as a
+// deposed object in "theirs" -- as left behind mid create_before_destroy
+// replacement -- with no entry at all in "base" or "ours". It must be
+// carried into the merged result rather than silently dropped.
+func TestMergeDeposedOnlyInstance(t *testing.T) {
+	addr := testInstanceAddr("replaced")
+	provider := testProviderAddr()
+	const deposedKey = states.DeposedKey("deadbeef")
+
+	base := testFile("abc", 1, func(ss *states.SyncState) {})
+	ours := testFile("abc", 2, func(ss *states.SyncState) {})
+	theirs := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceDeposed(addr, deposedKey, testObjSrc(`{"id":"old"}`), provider)
+	})
+
+	merged, conflicts, err := Merge(base, ours, theirs, MergeStrategyManual)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %#v", conflicts)
+	}
+
+	is := merged.State.ResourceInstance(addr)
+	if is == nil {
+		t.Fatal("deposed-only instance was dropped from the merged result")
+	}
+	obj, ok := is.Deposed[deposedKey]
+	if !ok {
+		t.Fatal("deposed object was dropped from the merged result")
+	}
+	if got, want := string(obj.AttrsJSON), `{"id":"old"}`; got != want {
+		t.Errorf("deposed object AttrsJSON = %s, want %s", got, want)
+	}
+}
+
+// TestMergeDeposedConflict exercises two sides that both changed the same
+// deposed object differently: it must be reported as a Conflict, not
+// silently resolved either way.
+func TestMergeDeposedConflict(t *testing.T) {
+	addr := testInstanceAddr("replaced")
+	provider := testProviderAddr()
+	const deposedKey = states.DeposedKey("deadbeef")
+
+	base := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceDeposed(addr, deposedKey, testObjSrc(`{"id":"base"}`), provider)
+	})
+	ours := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceDeposed(addr, deposedKey, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceDeposed(addr, deposedKey, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	_, conflicts, err := Merge(base, ours, theirs, MergeStrategyManual)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d: %#v", len(conflicts), conflicts)
+	}
+	if conflicts[0].DeposedKey != deposedKey {
+		t.Errorf("conflict has wrong deposed key: got %s, want %s", conflicts[0].DeposedKey, deposedKey)
+	}
+}
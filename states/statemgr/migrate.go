@@ -29,38 +29,6 @@ type Migrator interface {
 	WriteStateForMigration(*statefile.File) error
 }
 
-// Migrate writes the latest transient state snapshot from src into dest,
-// preserving snapshot metadata (serial and lineage) where possible.
-//
-// If both managers implement the optional interface Migrator then it will
-// be used to copy the snapshot and its associated metadata. Otherwise,
-// the normal Reader and Writer interfaces will be used instead.
-//
-// If the destination manager refuses the new state or fails to write it then
-// its error is returned directly.
-//
-// For state managers that also implement Persistent, it is the caller's
-// responsibility to persist the newly-written state after a successful result,
-// just as with calls to Writer.WriteState.
-//
-// This function doesn't do any locking of its own, so if the state managers
-// also implement Locker the caller should hold a lock on both managers
-// for the duration of this call.
-func Migrate(dst, src Transient) error {
-	if dstM, ok := dst.(Migrator); ok {
-		if srcM, ok := src.(Migrator); ok {
-			// Full-fidelity migration, them.
-			s := srcM.StateForMigration()
-			return dstM.WriteStateForMigration(s)
-		}
-	}
-
-	// Managers to not support full-fidelity migration, so migration will not
-	// preserve serial/lineage.
-	s := src.State()
-	return dst.WriteState(s)
-}
-
 // Import loads the given state snapshot into the given manager, preserving
 // its metadata (serial and lineage) if the target manager supports metadata.
 //
@@ -74,6 +42,25 @@ func Migrate(dst, src Transient) error {
 // "force" is set. "force" has no effect for managers that do not support
 // snapshot metadata.
 //
+// Before writing, Import runs the state being imported through every
+// Validator registered with RegisterValidator, in registration order, and
+// fails without writing anything if any of them returns an error.
+//
+// If the metadata doesn't match because the manager has the same serial with
+// different content -- a serial collision, meaning both "f" and the
+// manager's current snapshot were written on top of the same prior serial --
+// Import will attempt a three-way merge via Merge instead of failing
+// outright, provided the manager also implements HistoryReader so that the
+// snapshot at serial-1 can be retrieved as the common ancestor. The given
+// strategy controls how any conflicting resource instances found during
+// that merge are resolved; conflicts that remain unresolved are returned to
+// the caller rather than being written anywhere.
+//
+// A lineage mismatch or an import serial older than the manager's current
+// serial cannot be resolved this way, because there is no serial shared by
+// both sides to use as a starting point for locating a common ancestor, so
+// those are always rejected outright regardless of HistoryReader support.
+//
 // For state managers that also implement Persistent, it is the caller's
 // responsibility to persist the newly-written state after a successful result,
 // just as with calls to Writer.WriteState.
@@ -81,30 +68,62 @@ func Migrate(dst, src Transient) error {
 // This function doesn't do any locking of its own, so if the state manager
 // also implements Locker the caller should hold a lock on it for the
 // duration of this call.
-func Import(f *statefile.File, mgr Transient, force bool) error {
+func Import(f *statefile.File, mgr Transient, force bool, strategy MergeStrategy) ([]Conflict, error) {
+	if err := validateStateForImport(f); err != nil {
+		return nil, err
+	}
+
 	if mgrM, ok := mgr.(Migrator); ok {
 		m := mgrM.StateSnapshotMeta()
 		if f.Lineage != "" && m.Lineage != "" && !force {
-			if f.Lineage != m.Lineage {
-				return fmt.Errorf("cannot import state with lineage %q over unrelated state with lineage %q", f.Lineage, m.Lineage)
-			}
-			if f.Serial == m.Serial {
+			if f.Serial == m.Serial && f.Lineage == m.Lineage {
 				currentState := mgr.State()
 				if statefile.StatesMarshalEqual(f.State, currentState) {
 					// If lineage, serial, and state all match then this is a no-op.
-					return nil
+					return nil, nil
 				}
-				return fmt.Errorf("cannot overwrite existing state with serial %d with a different state that has the same serial", m.Serial)
-			} else if f.Serial < m.Serial {
-				return fmt.Errorf("cannot import state with serial %d over newer state with lineage %d", f.Serial, m.Serial)
+			}
+
+			if f.Lineage != m.Lineage {
+				return nil, fmt.Errorf("cannot import state with lineage %q over unrelated state with lineage %q", f.Lineage, m.Lineage)
+			}
+			if f.Serial < m.Serial {
+				return nil, fmt.Errorf("cannot import state with serial %d over newer state with serial %d", f.Serial, m.Serial)
+			}
+
+			if f.Serial == m.Serial {
+				// A genuine serial collision: both "f" and the manager's
+				// current snapshot descend from the snapshot at serial-1,
+				// so that's the common ancestor a three-way merge needs.
+				hist, ok := mgr.(HistoryReader)
+				if !ok || m.Serial == 0 {
+					return nil, fmt.Errorf("cannot overwrite existing state with serial %d with a different state that has the same serial", m.Serial)
+				}
+
+				base, err := hist.SnapshotAt(m.Lineage, m.Serial-1)
+				if err != nil {
+					return nil, fmt.Errorf("cannot find common ancestor snapshot to merge state with serial %d: %s", f.Serial, err)
+				}
+				ours := statefile.New(mgr.State(), m.Lineage, m.Serial)
+				merged, conflicts, err := Merge(base, ours, f, strategy)
+				if err != nil {
+					return nil, err
+				}
+				if len(conflicts) > 0 {
+					return conflicts, nil
+				}
+				if err := validateStateForImport(merged); err != nil {
+					return nil, err
+				}
+				f = merged
 			}
 		}
-		return mgrM.WriteStateForMigration(f)
+		return nil, mgrM.WriteStateForMigration(f)
 	}
 
 	// For managers that don't implement Migrator, this is just a normal write
 	// of the state contained in the given file.
-	return mgr.WriteState(f.State)
+	return nil, mgr.WriteState(f.State)
 }
 
 // Export retrieves the latest state snapshot from the given manager, including
@@ -132,4 +151,4 @@ func Export(mgr Reader) *statefile.File {
 		s := mgr.State()
 		return statefile.New(s, "", 0)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,185 @@
+package statemgr
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+)
+
+// fakeHistoryMgr is a minimal in-memory state manager used to exercise
+// Import's merge path. It implements Transient, Migrator, and HistoryReader.
+type fakeHistoryMgr struct {
+	current *statefile.File
+	history map[uint64]*statefile.File
+
+	// wrote records whether WriteStateForMigration was ever called, so
+	// tests can assert that a failed validator aborted before any write.
+	wrote bool
+}
+
+func (m *fakeHistoryMgr) State() *states.State {
+	return m.current.State
+}
+
+func (m *fakeHistoryMgr) WriteState(s *states.State) error {
+	m.current = statefile.New(s, m.current.Lineage, m.current.Serial)
+	return nil
+}
+
+func (m *fakeHistoryMgr) StateSnapshotMeta() StateSnapshotMeta {
+	return StateSnapshotMeta{Lineage: m.current.Lineage, Serial: m.current.Serial}
+}
+
+func (m *fakeHistoryMgr) StateForMigration() *statefile.File {
+	return m.current
+}
+
+func (m *fakeHistoryMgr) WriteStateForMigration(f *statefile.File) error {
+	m.wrote = true
+	m.history[f.Serial] = f
+	m.current = f
+	return nil
+}
+
+func (m *fakeHistoryMgr) SnapshotAt(lineage string, serial uint64) (*statefile.File, error) {
+	f, ok := m.history[serial]
+	if !ok || f.Lineage != lineage {
+		return nil, fmt.Errorf("no snapshot retained for lineage %q serial %d", lineage, serial)
+	}
+	return f, nil
+}
+
+func TestImportMergesOnSerialCollision(t *testing.T) {
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+
+	base := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"base"}`), provider)
+	})
+	ours := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	mgr := &fakeHistoryMgr{
+		current: ours,
+		history: map[uint64]*statefile.File{1: base},
+	}
+
+	conflicts, err := Import(theirs, mgr, false, MergeStrategyManual)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected a conflict for the divergent instance, got %d: %#v", len(conflicts), conflicts)
+	}
+	if got, want := conflicts[0].Addr.String(), addr.String(); got != want {
+		t.Errorf("wrong conflict address: got %s, want %s", got, want)
+	}
+
+	// Since the conflict wasn't resolved, nothing should have been written.
+	if mgr.current != ours {
+		t.Errorf("manager state was overwritten despite an unresolved conflict")
+	}
+}
+
+func TestImportMergeResolvesWithStrategy(t *testing.T) {
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+
+	base := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"base"}`), provider)
+	})
+	ours := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	mgr := &fakeHistoryMgr{
+		current: ours,
+		history: map[uint64]*statefile.File{1: base},
+	}
+
+	conflicts, err := Import(theirs, mgr, false, MergeStrategyTheirs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %#v", conflicts)
+	}
+
+	got := string(mgr.current.State.ResourceInstance(addr).Current.AttrsJSON)
+	if want := `{"id":"theirs"}`; got != want {
+		t.Errorf("manager state = %s, want %s", got, want)
+	}
+}
+
+func TestImportRejectsUnrelatedLineageWithoutMerging(t *testing.T) {
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+
+	ours := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("xyz", 5, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	mgr := &fakeHistoryMgr{
+		current: ours,
+		history: map[uint64]*statefile.File{},
+	}
+
+	if _, err := Import(theirs, mgr, false, MergeStrategyManual); err == nil {
+		t.Fatal("expected an error for unrelated lineage, got none")
+	}
+}
+
+// alwaysFailValidator is a Validator that always rejects, for exercising
+// that Import and Migrate actually stop before writing anything.
+type alwaysFailValidator struct{}
+
+func (alwaysFailValidator) ValidateStateForImport(*statefile.File) error {
+	return fmt.Errorf("rejected by alwaysFailValidator")
+}
+
+// TestImportAbortsOnValidatorFailure is the central guarantee the Validator
+// mechanism exists for: a registered validator that rejects a snapshot must
+// stop Import before it ever reaches WriteStateForMigration, leaving the
+// manager's state untouched.
+func TestImportAbortsOnValidatorFailure(t *testing.T) {
+	resetValidators(t)
+	RegisterValidator(alwaysFailValidator{})
+
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+
+	ours := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"ours"}`), provider)
+	})
+	theirs := testFile("abc", 2, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"theirs"}`), provider)
+	})
+
+	mgr := &fakeHistoryMgr{
+		current: ours,
+		history: map[uint64]*statefile.File{},
+	}
+
+	_, err := Import(theirs, mgr, false, MergeStrategyManual)
+	if err == nil {
+		t.Fatal("expected Import to fail because of the registered validator")
+	}
+	if mgr.wrote {
+		t.Error("Import wrote state despite a failing validator")
+	}
+	if mgr.current != ours {
+		t.Error("manager state was modified despite a failing validator")
+	}
+}
@@ -0,0 +1,105 @@
+package statemgr
+
+import (
+	"io"
+
+	"github.com/hashicorp/terraform/states/statefile"
+)
+
+// StreamMigrator is an optional interface implemented by state managers
+// that can migrate a state snapshot as a raw byte stream rather than as a
+// decoded *statefile.File, avoiding a full in-memory round trip through
+// states.State for very large state files.
+//
+// Migrate prefers this interface over Migrator when both the source and
+// destination managers support it.
+//
+// This is groundwork: as of this writing no state manager in this
+// repository (local file, S3, GCS, or otherwise) implements StreamMigrator
+// yet, so Migrate never actually takes the streaming path in practice. The
+// multi-hundred-megabyte-state problem this interface targets isn't solved
+// until at least one real backend implements it; that's tracked as
+// necessary follow-up work, not included here.
+type StreamMigrator interface {
+	PersistentMeta
+
+	// StateReaderForMigration returns a reader over the raw serialized
+	// bytes of the latest state snapshot (as would be produced by encoding
+	// the result of Reader.State with the statefile package), along with
+	// the associated snapshot metadata. The caller must close the returned
+	// reader once it is done with it.
+	StateReaderForMigration() (io.ReadCloser, StateSnapshotMeta, error)
+
+	// WriteStateStreamForMigration consumes the raw serialized bytes of a
+	// state snapshot from r, persists them verbatim, and then updates the
+	// snapshot metadata to match meta, as though WriteStateForMigration had
+	// been called with the decoded equivalent.
+	//
+	// Implementations should checksum the bytes read from r as they are
+	// written and fail if the stream is truncated or otherwise corrupted,
+	// since the content is never decoded far enough to be validated by
+	// normal JSON unmarshaling.
+	WriteStateStreamForMigration(r io.Reader, meta StateSnapshotMeta) error
+}
+
+// Migrate writes the latest transient state snapshot from src into dest,
+// preserving snapshot metadata (serial and lineage) where possible.
+//
+// If both managers implement the optional interface StreamMigrator, and no
+// Validator is currently registered with RegisterValidator, then the
+// snapshot is copied as a raw byte stream without being decoded into a
+// states.State. Otherwise, if both managers implement Migrator then that
+// interface is used to copy the snapshot and its associated metadata.
+// Otherwise, the normal Reader and Writer interfaces will be used instead.
+//
+// If the destination manager refuses the new state or fails to write it then
+// its error is returned directly.
+//
+// Before writing through the Migrator or Reader/Writer paths, Migrate runs
+// the source snapshot through every registered Validator, in registration
+// order, and fails without writing anything if any of them returns an
+// error. Validators are decoded-state checks and so cannot run against the
+// raw byte stream used by the StreamMigrator path; rather than silently
+// skipping them, Migrate avoids that path entirely whenever a validator is
+// registered, falling back to a full decode so the guardrails still apply.
+//
+// For state managers that also implement Persistent, it is the caller's
+// responsibility to persist the newly-written state after a successful result,
+// just as with calls to Writer.WriteState.
+//
+// This function doesn't do any locking of its own, so if the state managers
+// also implement Locker the caller should hold a lock on both managers
+// for the duration of this call.
+func Migrate(dst, src Transient) error {
+	if !hasValidators() {
+		if dstS, ok := dst.(StreamMigrator); ok {
+			if srcS, ok := src.(StreamMigrator); ok {
+				r, meta, err := srcS.StateReaderForMigration()
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				return dstS.WriteStateStreamForMigration(r, meta)
+			}
+		}
+	}
+
+	if dstM, ok := dst.(Migrator); ok {
+		if srcM, ok := src.(Migrator); ok {
+			// Full-fidelity migration, them.
+			s := srcM.StateForMigration()
+			if err := validateStateForImport(s); err != nil {
+				return err
+			}
+			return dstM.WriteStateForMigration(s)
+		}
+	}
+
+	// Managers to not support full-fidelity migration, so migration will not
+	// preserve serial/lineage.
+	s := src.State()
+	if err := validateStateForImport(statefile.New(s, "", 0)); err != nil {
+		return err
+	}
+	return dst.WriteState(s)
+}
@@ -0,0 +1,157 @@
+package statemgr
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hashicorp/terraform/states"
+	"github.com/hashicorp/terraform/states/statefile"
+)
+
+// fakeStreamMgr implements Transient, Migrator, and StreamMigrator, and
+// records which of the three paths Migrate used against it.
+type fakeStreamMgr struct {
+	current *statefile.File
+
+	usedStream   bool
+	usedMigrator bool
+	usedPlain    bool
+}
+
+func (m *fakeStreamMgr) State() *states.State {
+	return m.current.State
+}
+
+func (m *fakeStreamMgr) WriteState(s *states.State) error {
+	m.usedPlain = true
+	m.current = statefile.New(s, m.current.Lineage, m.current.Serial)
+	return nil
+}
+
+func (m *fakeStreamMgr) StateSnapshotMeta() StateSnapshotMeta {
+	return StateSnapshotMeta{Lineage: m.current.Lineage, Serial: m.current.Serial}
+}
+
+func (m *fakeStreamMgr) StateForMigration() *statefile.File {
+	return m.current
+}
+
+func (m *fakeStreamMgr) WriteStateForMigration(f *statefile.File) error {
+	m.usedMigrator = true
+	m.current = f
+	return nil
+}
+
+func (m *fakeStreamMgr) StateReaderForMigration() (io.ReadCloser, StateSnapshotMeta, error) {
+	return ioutil.NopCloser(bytes.NewReader([]byte(m.current.Lineage))), m.StateSnapshotMeta(), nil
+}
+
+func (m *fakeStreamMgr) WriteStateStreamForMigration(r io.Reader, meta StateSnapshotMeta) error {
+	m.usedStream = true
+	lineage, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.current = statefile.New(m.current.State, string(lineage), meta.Serial)
+	return nil
+}
+
+// resetValidators clears the package-level validator registry and restores
+// it once the calling test finishes, so tests that register validators
+// don't leak into each other.
+func resetValidators(t *testing.T) {
+	t.Helper()
+	validatorsMu.Lock()
+	prev := validators
+	validators = nil
+	validatorsMu.Unlock()
+
+	t.Cleanup(func() {
+		validatorsMu.Lock()
+		validators = prev
+		validatorsMu.Unlock()
+	})
+}
+
+func TestMigratePrefersStreamingWhenNoValidators(t *testing.T) {
+	resetValidators(t)
+
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+	f := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"a"}`), provider)
+	})
+
+	src := &fakeStreamMgr{current: f}
+	dst := &fakeStreamMgr{current: testFile("", 0, func(ss *states.SyncState) {})}
+
+	if err := Migrate(dst, src); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !dst.usedStream {
+		t.Errorf("expected the StreamMigrator path to be used")
+	}
+	if dst.usedMigrator || dst.usedPlain {
+		t.Errorf("expected only the StreamMigrator path to be used")
+	}
+}
+
+type noopValidator struct{}
+
+func (noopValidator) ValidateStateForImport(*statefile.File) error { return nil }
+
+func TestMigrateAvoidsStreamingWhenValidatorsRegistered(t *testing.T) {
+	resetValidators(t)
+	RegisterValidator(noopValidator{})
+
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+	f := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"a"}`), provider)
+	})
+
+	src := &fakeStreamMgr{current: f}
+	dst := &fakeStreamMgr{current: testFile("", 0, func(ss *states.SyncState) {})}
+
+	if err := Migrate(dst, src); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dst.usedStream {
+		t.Errorf("expected the StreamMigrator path to be skipped while a validator is registered")
+	}
+	if !dst.usedMigrator {
+		t.Errorf("expected the Migrator path to be used as a fallback")
+	}
+}
+
+// TestMigrateAbortsOnValidatorFailure exercises the decode fallback in
+// Migrate (the Migrator path, exactly like TestMigrateAvoidsStreamingWhenValidatorsRegistered
+// takes once a validator is registered): a failing validator must stop
+// Migrate before it ever reaches WriteStateForMigration, leaving the
+// destination manager's state untouched.
+func TestMigrateAbortsOnValidatorFailure(t *testing.T) {
+	resetValidators(t)
+	RegisterValidator(alwaysFailValidator{})
+
+	addr := testInstanceAddr("foo")
+	provider := testProviderAddr()
+	f := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(addr, testObjSrc(`{"id":"a"}`), provider)
+	})
+
+	src := &fakeStreamMgr{current: f}
+	dstFile := testFile("", 0, func(ss *states.SyncState) {})
+	dst := &fakeStreamMgr{current: dstFile}
+
+	if err := Migrate(dst, src); err == nil {
+		t.Fatal("expected Migrate to fail because of the registered validator")
+	}
+	if dst.usedStream || dst.usedMigrator || dst.usedPlain {
+		t.Error("Migrate wrote state despite a failing validator")
+	}
+	if dst.current != dstFile {
+		t.Error("destination manager state was modified despite a failing validator")
+	}
+}
@@ -0,0 +1,66 @@
+package statemgr
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/terraform/states/statefile"
+)
+
+// Validator is implemented by pre-write checks that Import and Migrate run
+// against an incoming state snapshot before it is handed to a manager's
+// WriteStateForMigration. Validators are a way to run operational
+// guardrails, such as rejecting a Terraform version downgrade or an
+// incompatible provider schema, centrally rather than having every state
+// manager reimplement them.
+type Validator interface {
+	// ValidateStateForImport inspects the given snapshot and returns an
+	// error if it should not be written. It must not modify f.
+	ValidateStateForImport(f *statefile.File) error
+}
+
+var (
+	validatorsMu sync.Mutex
+	validators   []Validator
+)
+
+// RegisterValidator adds v to the chain of validators run by Import and
+// Migrate before a state snapshot is written. Validators run in the order
+// they were registered, and the first one to return an error aborts the
+// write; later validators are not run.
+//
+// RegisterValidator is typically called from an init function of a package
+// that wants to enforce a custom guardrail, such as a policy-as-code check,
+// on every state write that goes through Import or Migrate.
+func RegisterValidator(v Validator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators = append(validators, v)
+}
+
+// hasValidators reports whether any validator is currently registered.
+//
+// Migrate uses this to decide whether it's safe to take the StreamMigrator
+// path, which copies a snapshot without ever decoding it and so cannot run
+// any registered validator against it.
+func hasValidators() bool {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	return len(validators) > 0
+}
+
+// validateStateForImport runs the registered validator chain against f,
+// returning the first error encountered, if any.
+func validateStateForImport(f *statefile.File) error {
+	validatorsMu.Lock()
+	vs := make([]Validator, len(validators))
+	copy(vs, validators)
+	validatorsMu.Unlock()
+
+	for _, v := range vs {
+		if err := v.ValidateStateForImport(f); err != nil {
+			return fmt.Errorf("state validation failed: %s", err)
+		}
+	}
+	return nil
+}
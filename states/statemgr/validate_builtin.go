@@ -0,0 +1,136 @@
+package statemgr
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/providers"
+	"github.com/hashicorp/terraform/states/statefile"
+	tfversion "github.com/hashicorp/terraform/version"
+)
+
+// RegisterDefaultValidators registers the built-in validators that are safe
+// to enable without any caller-supplied configuration: VersionValidator and
+// OrphanedModuleValidator. It does not register ProviderSchemaValidator,
+// which needs the currently-installed provider schemas to be useful.
+//
+// RegisterDefaultValidators is not called automatically by this package,
+// because both built-ins are heuristics that can legitimately misfire
+// against pre-existing state -- OrphanedModuleValidator in particular can
+// flag module entries left over from before Terraform started pruning them
+// automatically, and a downgraded Terraform binary is sometimes a deliberate
+// and safe operator choice. Registering validators process-wide with no way
+// to opt out would silently change the behavior of every existing caller of
+// Import and Migrate. A CLI entrypoint (or other caller that wants these
+// guardrails on by default) should call RegisterDefaultValidators
+// explicitly, typically guarded by its own flag so operators can decline it.
+func RegisterDefaultValidators() {
+	RegisterValidator(VersionValidator{})
+	RegisterValidator(OrphanedModuleValidator{})
+}
+
+// VersionValidator is a built-in Validator that rejects importing a state
+// snapshot written by a newer Terraform than the one currently running,
+// since writing it back out with an older version could silently downgrade
+// its schema and corrupt the snapshot.
+//
+// VersionValidator is not registered by default; see RegisterDefaultValidators.
+type VersionValidator struct{}
+
+var _ Validator = VersionValidator{}
+
+func (VersionValidator) ValidateStateForImport(f *statefile.File) error {
+	if f.TerraformVersion == nil {
+		return nil
+	}
+	if f.TerraformVersion.GreaterThan(tfversion.SemVer) {
+		return fmt.Errorf(
+			"state was written by Terraform %s, which is newer than the current version %s; upgrade Terraform before importing this state",
+			f.TerraformVersion, tfversion.SemVer,
+		)
+	}
+	return nil
+}
+
+// ProviderSchemaValidator is a built-in Validator that checks each resource
+// instance's stored attributes against the schema of its provider, as known
+// to the currently-installed providers. It catches a state snapshot that
+// refers to a resource type or attribute that the installed provider
+// version no longer understands.
+//
+// Unlike VersionValidator and OrphanedModuleValidator, ProviderSchemaValidator
+// isn't registered by RegisterDefaultValidators, because it needs the currently-installed
+// provider schemas, which aren't available at init time. Whoever has those
+// schemas on hand -- typically wherever providers are installed and
+// instantiated -- should call RegisterValidator with a configured instance.
+type ProviderSchemaValidator struct {
+	// Schemas provides the schemas for the providers that are currently
+	// installed, keyed by provider source address.
+	Schemas providers.Schemas
+}
+
+var _ Validator = ProviderSchemaValidator{}
+
+func (v ProviderSchemaValidator) ValidateStateForImport(f *statefile.File) error {
+	for _, ms := range f.State.Modules {
+		for _, rs := range ms.Resources {
+			schema, _ := v.Schemas.SchemaForResourceType(rs.Addr.Resource.Mode, rs.Addr.Resource.Type, rs.ProviderConfig.Provider)
+			if schema == nil {
+				// No installed provider claims this resource type, so there's
+				// nothing to validate its attributes against here; that's a
+				// separate, pre-existing problem reported elsewhere.
+				continue
+			}
+			for key, is := range rs.Instances {
+				if is.Current == nil {
+					continue
+				}
+				if _, err := is.Current.Decode(schema.ImpliedType()); err != nil {
+					addr := rs.Addr.Instance(key).Absolute(ms.Addr)
+					return fmt.Errorf("%s: attributes don't conform to the current provider schema: %s", addr, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// OrphanedModuleValidator is a built-in Validator that flags module entries
+// left behind in a state snapshot with no resources of their own, no
+// descendant module with resources, and no output values of their own,
+// which usually indicates a stale entry that should have been pruned when
+// its resources were destroyed.
+//
+// A module with output values but no resources is left alone even though it
+// has nothing of its own to show, since that's the legitimate shape of a
+// pass-through module that only re-exports values computed elsewhere.
+//
+// OrphanedModuleValidator is not registered by default; see
+// RegisterDefaultValidators.
+type OrphanedModuleValidator struct{}
+
+var _ Validator = OrphanedModuleValidator{}
+
+func (OrphanedModuleValidator) ValidateStateForImport(f *statefile.File) error {
+	hasDescendantResources := make(map[string]bool)
+
+	for _, ms := range f.State.Modules {
+		if len(ms.Resources) == 0 {
+			continue
+		}
+		for addr := ms.Addr; !addr.IsRoot(); {
+			parent, _ := addr.Call()
+			hasDescendantResources[parent.String()] = true
+			addr = parent
+		}
+	}
+
+	for _, ms := range f.State.Modules {
+		if ms.Addr.IsRoot() || len(ms.Resources) > 0 || len(ms.OutputValues) > 0 {
+			continue
+		}
+		if !hasDescendantResources[ms.Addr.String()] {
+			return fmt.Errorf("%s: orphaned module has no resources of its own or in any descendant module", ms.Addr)
+		}
+	}
+	return nil
+}
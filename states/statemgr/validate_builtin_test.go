@@ -0,0 +1,122 @@
+package statemgr
+
+import (
+	"testing"
+
+	version "github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform/addrs"
+	"github.com/hashicorp/terraform/states"
+	tfversion "github.com/hashicorp/terraform/version"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestBuiltinValidatorsNotRegisteredByDefault(t *testing.T) {
+	validatorsMu.Lock()
+	registered := append([]Validator(nil), validators...)
+	validatorsMu.Unlock()
+
+	for _, v := range registered {
+		switch v.(type) {
+		case VersionValidator:
+			t.Error("VersionValidator must not be registered until RegisterDefaultValidators is called")
+		case OrphanedModuleValidator:
+			t.Error("OrphanedModuleValidator must not be registered until RegisterDefaultValidators is called")
+		case ProviderSchemaValidator:
+			t.Error("ProviderSchemaValidator must never be registered automatically; it needs explicit schemas")
+		}
+	}
+}
+
+func TestRegisterDefaultValidators(t *testing.T) {
+	resetValidators(t)
+	RegisterDefaultValidators()
+
+	var sawVersion, sawOrphanedModule bool
+	for _, v := range validators {
+		switch v.(type) {
+		case VersionValidator:
+			sawVersion = true
+		case OrphanedModuleValidator:
+			sawOrphanedModule = true
+		}
+	}
+	if !sawVersion {
+		t.Error("RegisterDefaultValidators did not register VersionValidator")
+	}
+	if !sawOrphanedModule {
+		t.Error("RegisterDefaultValidators did not register OrphanedModuleValidator")
+	}
+}
+
+func TestVersionValidatorRejectsDowngrade(t *testing.T) {
+	// 99.0.0 is newer than any real released Terraform, so it's always
+	// greater than the version running this test.
+	newer, err := version.NewVersion("99.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := testFile("abc", 1, func(ss *states.SyncState) {})
+	f.TerraformVersion = newer
+
+	if err := (VersionValidator{}).ValidateStateForImport(f); err == nil {
+		t.Fatal("expected an error for a state written by a newer Terraform version")
+	}
+
+	f.TerraformVersion = tfversion.SemVer
+	if err := (VersionValidator{}).ValidateStateForImport(f); err != nil {
+		t.Fatalf("unexpected error for a state written by the current version: %s", err)
+	}
+}
+
+func TestOrphanedModuleValidatorIgnoresEmptyRoot(t *testing.T) {
+	f := testFile("abc", 1, func(ss *states.SyncState) {
+		// No resources at all: the root module is empty, but the root is
+		// never itself considered "orphaned".
+	})
+
+	if err := (OrphanedModuleValidator{}).ValidateStateForImport(f); err != nil {
+		t.Fatalf("root module should never be flagged as orphaned: %s", err)
+	}
+}
+
+// TestOrphanedModuleValidatorIgnoresPassthroughModule exercises a module
+// with no resources of its own but a recorded output value: a legitimate,
+// currently-empty module that only re-exports values, not an orphan.
+func TestOrphanedModuleValidatorIgnoresPassthroughModule(t *testing.T) {
+	provider := testProviderAddr()
+	rootThing := testInstanceAddr("root_thing")
+
+	f := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(rootThing, testObjSrc(`{"id":"a"}`), provider)
+	})
+
+	passthrough := addrs.RootModuleInstance.Child("passthrough", addrs.NoKey)
+	child := states.NewModule(passthrough)
+	child.OutputValues["out"] = &states.OutputValue{Value: cty.StringVal("hello")}
+	f.State.Modules[passthrough.String()] = child
+
+	if err := (OrphanedModuleValidator{}).ValidateStateForImport(f); err != nil {
+		t.Fatalf("a module with outputs but no resources should not be flagged as orphaned: %s", err)
+	}
+}
+
+// TestOrphanedModuleValidatorFlagsStaleEmptyModule exercises the case the
+// validator exists for: a leftover module entry with no resources, no
+// outputs, and no descendant with resources either -- the kind of entry
+// that predates automatic pruning of empty modules.
+func TestOrphanedModuleValidatorFlagsStaleEmptyModule(t *testing.T) {
+	provider := testProviderAddr()
+	rootThing := testInstanceAddr("root_thing")
+
+	f := testFile("abc", 1, func(ss *states.SyncState) {
+		ss.SetResourceInstanceCurrent(rootThing, testObjSrc(`{"id":"a"}`), provider)
+	})
+
+	stale := addrs.RootModuleInstance.Child("stale", addrs.NoKey)
+	f.State.Modules[stale.String()] = states.NewModule(stale)
+
+	if err := (OrphanedModuleValidator{}).ValidateStateForImport(f); err == nil {
+		t.Fatal("expected an error for a genuinely orphaned empty module")
+	}
+}